@@ -2,24 +2,43 @@ package modules
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
 	"github.com/bettercap/bettercap/log"
 	"github.com/bettercap/bettercap/session"
-	"github.com/bettercap/bettercap/tls"
+	btls "github.com/bettercap/bettercap/tls"
 
 	"github.com/evilsocket/islazy/fs"
 	"github.com/evilsocket/islazy/tui"
 )
 
+// tlsVersions maps the human readable TLS version names accepted by the
+// http.server.tls.min_version and http.server.tls.max_version parameters
+// to their crypto/tls constants.
+var tlsVersions = map[string]uint16{
+	"TLS1.0": tls.VersionTLS10,
+	"TLS1.1": tls.VersionTLS11,
+	"TLS1.2": tls.VersionTLS12,
+	"TLS1.3": tls.VersionTLS13,
+}
+
 type HttpServer struct {
 	session.SessionModule
-	server   *http.Server
-	certFile string
-	keyFile  string
+	server         *http.Server
+	certFile       string
+	keyFile        string
+	acmeManager    *autocert.Manager
+	acmeHTTPServer *http.Server
 }
 
 func NewHttpServer(s *session.Session) *HttpServer {
@@ -52,7 +71,88 @@ func NewHttpServer(s *session.Session) *HttpServer {
 		"",
 		"TLS key file, if not empty will configure this as a HTTPS server (will be auto generated if filled but not existing)."))
 
-	tls.CertConfigToModule("http.server", &httpd.SessionModule, tls.DefaultLegitConfig)
+	btls.CertConfigToModule("http.server", &httpd.SessionModule, btls.DefaultLegitConfig)
+
+	httpd.AddParam(session.NewBoolParameter("http.server.http2",
+		"false",
+		"If true, the server will also speak HTTP/2: h2 negotiated with clients when TLS is enabled, h2c on plaintext connections otherwise."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.tls.min_version",
+		"",
+		"",
+		"Minimum TLS version to accept, one of TLS1.0, TLS1.1, TLS1.2 or TLS1.3 (empty for Go's default)."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.tls.max_version",
+		"",
+		"",
+		"Maximum TLS version to accept, one of TLS1.0, TLS1.1, TLS1.2 or TLS1.3 (empty for Go's default)."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.tls.ciphers",
+		"",
+		"",
+		"Comma separated list of TLS cipher suite names to restrict the server to (empty for Go's default); TLS 1.3 cipher suites are not affected."))
+
+	httpd.AddParam(session.NewBoolParameter("http.server.acme.enabled",
+		"false",
+		"If true, obtain and renew the server's TLS certificate automatically via ACME (Let's Encrypt) instead of using http.server.certificate and http.server.key."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.acme.domains",
+		"",
+		"",
+		"Comma separated list of domain names to request the ACME certificate for."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.acme.cache_dir",
+		"~/.bettercap-acme",
+		"",
+		"Directory where the ACME manager will cache issued certificates and account keys."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.acme.email",
+		"",
+		"",
+		"Contact email address to register with the ACME CA (optional)."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.basic_auth",
+		"",
+		"",
+		"If not empty, in the user:pass form, requests will be protected with HTTP Basic Authentication."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.upload.path",
+		"",
+		"",
+		"If not empty, PUT and POST requests will save their body as a file in this directory."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.routes",
+		"",
+		"",
+		"JSON object mapping URL prefixes to either {\"dir\":\"...\"}, {\"file\":\"...\"} or {\"upstream\":\"http://...\"} to serve a subdirectory, a static file or a reverse proxied upstream from that prefix."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.access_log",
+		"",
+		"",
+		"If not empty, every request will be appended to this file in Combined Log Format."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.template_ext",
+		".tpl",
+		"",
+		"Files under http.server.path with this extension will be rendered through html/template with the incoming request exposed as data, instead of being served as-is."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.proxy_target",
+		"",
+		"",
+		"If not empty, requests will be reverse proxied to this upstream URL instead of being served from http.server.path, effectively cloning the target site."))
+
+	httpd.AddParam(session.NewStringParameter("http.server.inject",
+		"",
+		"",
+		"HTML snippet to inject into every text/html response right before the closing </body> tag, used together with http.server.proxy_target."))
+
+	httpd.AddParam(session.NewBoolParameter("http.server.proxy_strip_hsts",
+		"true",
+		"If true, the Strict-Transport-Security header will be stripped from proxied responses."))
+
+	httpd.AddParam(session.NewBoolParameter("http.server.proxy_rewrite_links",
+		"true",
+		"If true, absolute URLs in proxied text/html responses pointing back to http.server.proxy_target will be rewritten to point to the local host."))
 
 	httpd.AddHandler(session.NewModuleHandler("http.server on", "",
 		"Start httpd server.",
@@ -82,7 +182,126 @@ func (httpd *HttpServer) Author() string {
 }
 
 func (httpd *HttpServer) isTLS() bool {
-	return httpd.certFile != "" && httpd.keyFile != ""
+	return httpd.acmeManager != nil || (httpd.certFile != "" && httpd.keyFile != "")
+}
+
+// buildACMEManager reads the http.server.acme.* parameters and, if ACME is
+// enabled, returns a configured autocert.Manager that will obtain and renew
+// the server's TLS certificate from the configured CA (Let's Encrypt by
+// default) instead of relying on a self-signed or manually provided one.
+func (httpd *HttpServer) buildACMEManager() (*autocert.Manager, error) {
+	var err error
+	var enabled bool
+	var domainsParam string
+	var cacheDir string
+	var email string
+
+	if err, enabled = httpd.BoolParam("http.server.acme.enabled"); err != nil {
+		return nil, err
+	} else if !enabled {
+		return nil, nil
+	}
+
+	if err, domainsParam = httpd.StringParam("http.server.acme.domains"); err != nil {
+		return nil, err
+	} else if domainsParam == "" {
+		return nil, fmt.Errorf("http.server.acme.enabled is true but http.server.acme.domains is empty")
+	}
+
+	if err, cacheDir = httpd.StringParam("http.server.acme.cache_dir"); err != nil {
+		return nil, err
+	} else if cacheDir, err = fs.Expand(cacheDir); err != nil {
+		return nil, err
+	}
+
+	if err, email = httpd.StringParam("http.server.acme.email"); err != nil {
+		return nil, err
+	}
+
+	domains := make([]string, 0)
+	for _, domain := range strings.Split(domainsParam, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      email,
+	}
+
+	return manager, nil
+}
+
+// cipherSuiteByName resolves a Go crypto/tls cipher suite name (eg.
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256") to its numeric identifier.
+func cipherSuiteByName(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown TLS cipher suite '%s'", name)
+}
+
+// buildTLSConfig reads http.server.tls.min_version, http.server.tls.max_version
+// and http.server.tls.ciphers and returns a *tls.Config the server can use to
+// pin its TLS profile instead of relying on Go's defaults.
+func (httpd *HttpServer) buildTLSConfig() (*tls.Config, error) {
+	var err error
+	var minVersion, maxVersion, ciphers string
+
+	if err, minVersion = httpd.StringParam("http.server.tls.min_version"); err != nil {
+		return nil, err
+	}
+
+	if err, maxVersion = httpd.StringParam("http.server.tls.max_version"); err != nil {
+		return nil, err
+	}
+
+	if err, ciphers = httpd.StringParam("http.server.tls.ciphers"); err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		NextProtos: []string{"http/1.1"},
+	}
+
+	if minVersion != "" {
+		version, found := tlsVersions[minVersion]
+		if !found {
+			return nil, fmt.Errorf("unknown TLS version '%s'", minVersion)
+		}
+		cfg.MinVersion = version
+	}
+
+	if maxVersion != "" {
+		version, found := tlsVersions[maxVersion]
+		if !found {
+			return nil, fmt.Errorf("unknown TLS version '%s'", maxVersion)
+		}
+		cfg.MaxVersion = version
+	}
+
+	if ciphers != "" {
+		for _, name := range strings.Split(ciphers, ",") {
+			name = strings.TrimSpace(name)
+			id, err := cipherSuiteByName(name)
+			if err != nil {
+				return nil, err
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	return cfg, nil
 }
 
 func (httpd *HttpServer) Configure() error {
@@ -101,13 +320,93 @@ func (httpd *HttpServer) Configure() error {
 		return err
 	}
 
-	router := http.NewServeMux()
-	fileServer := http.FileServer(http.Dir(path))
+	var proxyTarget string
+	if err, proxyTarget = httpd.StringParam("http.server.proxy_target"); err != nil {
+		return err
+	}
+
+	var contentHandler http.Handler
+
+	if proxyTarget != "" {
+		var inject string
+		var stripHSTS bool
+		var rewriteLinks bool
+
+		if err, inject = httpd.StringParam("http.server.inject"); err != nil {
+			return err
+		}
 
-	router.HandleFunc("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err, stripHSTS = httpd.BoolParam("http.server.proxy_strip_hsts"); err != nil {
+			return err
+		}
+
+		if err, rewriteLinks = httpd.BoolParam("http.server.proxy_rewrite_links"); err != nil {
+			return err
+		}
+
+		if contentHandler, err = buildProxyHandler(proxyTarget, inject, stripHSTS, rewriteLinks); err != nil {
+			return err
+		}
+	} else {
+		var templateExt string
+		if err, templateExt = httpd.StringParam("http.server.template_ext"); err != nil {
+			return err
+		}
+
+		contentHandler = newTemplateFileServer(path, templateExt, http.FileServer(http.Dir(path)))
+	}
+
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Info("(%s) %s %s %s%s", tui.Green("httpd"), tui.Bold(strings.Split(r.RemoteAddr, ":")[0]), r.Method, r.Host, r.URL.Path)
-		fileServer.ServeHTTP(w, r)
-	}))
+		contentHandler.ServeHTTP(w, r)
+	})
+
+	var uploadPath string
+	if err, uploadPath = httpd.StringParam("http.server.upload.path"); err != nil {
+		return err
+	} else if uploadPath != "" {
+		if uploadPath, err = fs.Expand(uploadPath); err != nil {
+			return err
+		}
+		handler = uploadHandler(uploadPath, handler)
+	}
+
+	var routesParam string
+	if err, routesParam = httpd.StringParam("http.server.routes"); err != nil {
+		return err
+	}
+	routes, err := parseRoutes(routesParam)
+	if err != nil {
+		return err
+	}
+	if handler, err = buildRoutesHandler(routes, handler); err != nil {
+		return err
+	}
+
+	var basicAuth string
+	if err, basicAuth = httpd.StringParam("http.server.basic_auth"); err != nil {
+		return err
+	} else if basicAuth != "" {
+		parts := strings.SplitN(basicAuth, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("http.server.basic_auth must be in the user:pass form")
+		}
+		handler = basicAuthMiddleware(parts[0], parts[1], handler)
+	}
+
+	var accessLog string
+	if err, accessLog = httpd.StringParam("http.server.access_log"); err != nil {
+		return err
+	} else if accessLog != "" {
+		if handler, err = accessLogMiddleware(accessLog, handler); err != nil {
+			return err
+		}
+	}
+
+	handler = httpd.eventMiddleware(handler)
+
+	router := http.NewServeMux()
+	router.Handle("/", handler)
 
 	httpd.server.Handler = router
 
@@ -133,9 +432,17 @@ func (httpd *HttpServer) Configure() error {
 		return err
 	}
 
-	if certFile != "" && keyFile != "" {
+	acmeManager, err := httpd.buildACMEManager()
+	if err != nil {
+		return err
+	}
+	httpd.acmeManager = acmeManager
+
+	if acmeManager != nil {
+		log.Info("ACME TLS management enabled, certificates will be obtained on demand")
+	} else if certFile != "" && keyFile != "" {
 		if !fs.Exists(certFile) || !fs.Exists(keyFile) {
-			err, cfg := tls.CertConfigFromModule("http.server", httpd.SessionModule)
+			err, cfg := btls.CertConfigFromModule("http.server", httpd.SessionModule)
 			if err != nil {
 				return err
 			}
@@ -143,7 +450,7 @@ func (httpd *HttpServer) Configure() error {
 			log.Debug("%+v", cfg)
 			log.Info("Generating server TLS key to %s", keyFile)
 			log.Info("Generating server TLS certificate to %s", certFile)
-			if err := tls.Generate(cfg, certFile, keyFile); err != nil {
+			if err := btls.Generate(cfg, certFile, keyFile); err != nil {
 				return err
 			}
 		} else {
@@ -165,10 +472,51 @@ func (httpd *HttpServer) Start() error {
 
 	return httpd.SetRunning(true, func() {
 		var err error
+		var http2Enabled bool
+
+		if err, http2Enabled = httpd.BoolParam("http.server.http2"); err != nil {
+			panic(err)
+		}
+
 		if httpd.isTLS() {
+			var tlsConfig *tls.Config
+			if tlsConfig, err = httpd.buildTLSConfig(); err != nil {
+				panic(err)
+			}
+
+			if httpd.acmeManager != nil {
+				tlsConfig.GetCertificate = httpd.acmeManager.GetCertificate
+				tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+				httpd.startACMEChallengeServer()
+			} else {
+				cert, err := tls.LoadX509KeyPair(httpd.certFile, httpd.keyFile)
+				if err != nil {
+					panic(err)
+				}
+				tlsConfig.Certificates = []tls.Certificate{cert}
+			}
+
+			if http2Enabled {
+				tlsConfig.NextProtos = append([]string{"h2"}, tlsConfig.NextProtos...)
+				if err := http2.ConfigureServer(httpd.server, &http2.Server{}); err != nil {
+					panic(err)
+				}
+			}
+
+			httpd.server.TLSConfig = tlsConfig
+
+			var listener net.Listener
+			if listener, err = tls.Listen("tcp", httpd.server.Addr, tlsConfig); err != nil {
+				panic(err)
+			}
+
 			log.Info("HTTPS server starting on https://%s", httpd.server.Addr)
-			err = httpd.server.ListenAndServeTLS(httpd.certFile, httpd.keyFile)
+			err = httpd.server.Serve(listener)
 		} else {
+			if http2Enabled {
+				h2s := &http2.Server{}
+				httpd.server.Handler = h2c.NewHandler(httpd.server.Handler, h2s)
+			}
 			log.Info("HTTP server starting on http://%s", httpd.server.Addr)
 			err = httpd.server.ListenAndServe()
 		}
@@ -178,10 +526,35 @@ func (httpd *HttpServer) Start() error {
 	})
 }
 
+// startACMEChallengeServer spins up a plaintext listener on port 80 serving
+// the ACME HTTP-01 challenge handler, so that domain validation still works
+// when the main httpd server is bound to a different port (eg. 443).
+func (httpd *HttpServer) startACMEChallengeServer() {
+	if strings.HasSuffix(httpd.server.Addr, ":80") {
+		return
+	}
+
+	httpd.acmeHTTPServer = &http.Server{
+		Addr:    ":80",
+		Handler: httpd.acmeManager.HTTPHandler(nil),
+	}
+
+	go func() {
+		log.Info("ACME HTTP-01 challenge server starting on http://%s", httpd.acmeHTTPServer.Addr)
+		if err := httpd.acmeHTTPServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("error starting ACME challenge server: %v", err)
+		}
+	}()
+}
+
 func (httpd *HttpServer) Stop() error {
 	return httpd.SetRunning(false, func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 		defer cancel()
 		httpd.server.Shutdown(ctx)
+		if httpd.acmeHTTPServer != nil {
+			httpd.acmeHTTPServer.Shutdown(ctx)
+			httpd.acmeHTTPServer = nil
+		}
 	})
 }
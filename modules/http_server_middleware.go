@@ -0,0 +1,238 @@
+package modules
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bettercap/bettercap/log"
+
+	"github.com/evilsocket/islazy/fs"
+	"github.com/evilsocket/islazy/tui"
+)
+
+// httpServerRoute describes a single entry of the http.server.routes mapping:
+// a URL prefix routed either to a local directory, a static response file, or
+// a reverse proxied upstream.
+type httpServerRoute struct {
+	Dir      string `json:"dir,omitempty"`
+	File     string `json:"file,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// parseRoutes decodes the JSON object passed via http.server.routes, mapping
+// each URL prefix to the local directory, static file or upstream it should
+// be served from.
+func parseRoutes(raw string) (map[string]httpServerRoute, error) {
+	routes := make(map[string]httpServerRoute)
+	if raw == "" {
+		return routes, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &routes); err != nil {
+		return nil, fmt.Errorf("error parsing http.server.routes: %v", err)
+	}
+
+	return routes, nil
+}
+
+// buildRoutesHandler wraps next with handlers for every configured virtual
+// route, falling back to next for any request that doesn't match one.
+func buildRoutesHandler(routes map[string]httpServerRoute, next http.Handler) (http.Handler, error) {
+	if len(routes) == 0 {
+		return next, nil
+	}
+
+	type compiledRoute struct {
+		prefix  string
+		handler http.Handler
+	}
+
+	compiled := make([]compiledRoute, 0, len(routes))
+
+	for prefix, route := range routes {
+		var handler http.Handler
+
+		switch {
+		case route.Upstream != "":
+			proxy, err := buildProxyHandler(route.Upstream, "", false, false)
+			if err != nil {
+				return nil, fmt.Errorf("error building upstream for route '%s': %v", prefix, err)
+			}
+			handler = proxy
+
+		case route.Dir != "":
+			handler = http.StripPrefix(prefix, http.FileServer(http.Dir(route.Dir)))
+
+		case route.File != "":
+			file := route.File
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeFile(w, r, file)
+			})
+
+		default:
+			return nil, fmt.Errorf("route '%s' needs one of 'dir', 'file' or 'upstream'", prefix)
+		}
+
+		compiled = append(compiled, compiledRoute{prefix: prefix, handler: handler})
+	}
+
+	// longest (most specific) prefix first, so overlapping routes (eg. "/"
+	// and "/admin") have a deterministic precedence instead of depending on
+	// Go's randomized map iteration order.
+	sort.Slice(compiled, func(i, j int) bool {
+		return len(compiled[i].prefix) > len(compiled[j].prefix)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, route := range compiled {
+			if strings.HasPrefix(r.URL.Path, route.prefix) {
+				route.handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// basicAuthMiddleware wraps next with HTTP Basic Authentication, checking
+// the provided credentials against user:pass.
+func basicAuthMiddleware(user, pass string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqUser, reqPass, ok := r.BasicAuth()
+		if !ok || reqUser != user || reqPass != pass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="bettercap"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// uploadHandler serves PUT and POST requests by writing the request body to
+// uploadPath, named after the last element of the request path, and defers
+// to next for every other method.
+func uploadHandler(uploadPath string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name := filepath.Base(r.URL.Path)
+		if name == "" || name == "." || name == "/" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		dest := filepath.Join(uploadPath, name)
+		out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			log.Error("error creating uploaded file %s: %v", dest, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer out.Close()
+
+		written, err := io.Copy(out, r.Body)
+		if err != nil {
+			log.Error("error saving uploaded file %s: %v", dest, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("(%s) uploaded %s (%d bytes)", tui.Green("httpd"), dest, written)
+		w.WriteHeader(http.StatusCreated)
+	})
+}
+
+// statusRecorder wraps a http.ResponseWriter to capture the response status
+// code and number of bytes written, for access logging purposes.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Hijack forwards to the underlying ResponseWriter's http.Hijacker, so that
+// protocol-switching upgrades (eg. WebSocket) still work through a proxied
+// route when http.server.access_log is enabled.
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Flush forwards to the underlying ResponseWriter's http.Flusher, if any.
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// accessLogMiddleware wraps next, appending one Combined Log Format line per
+// request (plus the request duration in milliseconds as a trailing field) to
+// the file at logPath.
+func accessLogMiddleware(logPath string, next http.Handler) (http.Handler, error) {
+	logPath, err := fs.Expand(logPath)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening http.server.access_log '%s': %v", logPath, err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: 0}
+
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			// nothing was written, net/http sends an implicit 200
+			rec.status = http.StatusOK
+		}
+
+		host := strings.Split(r.RemoteAddr, ":")[0]
+		user := "-"
+		if u, _, ok := r.BasicAuth(); ok {
+			user = u
+		}
+
+		line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %d \"%s\" \"%s\" %dms\n",
+			host, user, started.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method, r.URL.RequestURI(), r.Proto,
+			rec.status, rec.bytes,
+			r.Referer(), r.UserAgent(),
+			time.Since(started).Milliseconds())
+
+		if _, err := file.WriteString(line); err != nil {
+			log.Error("error writing to http.server.access_log: %v", err)
+		}
+	}), nil
+}
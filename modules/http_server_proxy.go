@@ -0,0 +1,96 @@
+package modules
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// buildProxyHandler returns a http.Handler that transparently reverse
+// proxies every request to target, optionally stripping the
+// Strict-Transport-Security header, rewriting absolute links pointing back
+// to target so the client keeps talking to the local host, and injecting
+// an HTML snippet into text/html responses. This turns http.server into a
+// self-contained site-cloning front-end.
+func buildProxyHandler(target string, inject string, stripHSTS bool, rewriteLinks bool) (http.Handler, error) {
+	upstream, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing http.server.proxy_target '%s': %v", target, err)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+
+	// localOriginHeader carries the client-facing scheme+host through the
+	// roundtrip so ModifyResponse can rewrite links back to it, since the
+	// Director below overwrites the request's Host with the upstream one.
+	const localOriginHeader = "X-Bettercap-Local-Origin"
+
+	originalDirector := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		localScheme := "http"
+		if r.TLS != nil {
+			localScheme = "https"
+		}
+		r.Header.Set(localOriginHeader, localScheme+"://"+r.Host)
+
+		originalDirector(r)
+		r.Host = upstream.Host
+		r.Header.Set("Origin", upstream.Scheme+"://"+upstream.Host)
+		r.Header.Set("Referer", upstream.Scheme+"://"+upstream.Host+"/")
+		// the response body is rewritten below, so ask upstream not to
+		// compress it rather than trying to rewrite compressed bytes.
+		r.Header.Del("Accept-Encoding")
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		localOrigin := resp.Request.Header.Get(localOriginHeader)
+		resp.Request.Header.Del(localOriginHeader)
+
+		if stripHSTS {
+			resp.Header.Del("Strict-Transport-Security")
+		}
+
+		if !rewriteLinks && inject == "" {
+			return nil
+		}
+
+		contentType := resp.Header.Get("Content-Type")
+		if !strings.Contains(contentType, "text/html") {
+			return nil
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		html := string(body)
+
+		if rewriteLinks && localOrigin != "" {
+			html = strings.ReplaceAll(html, upstream.Scheme+"://"+upstream.Host, localOrigin)
+			html = strings.ReplaceAll(html, "//"+upstream.Host, "//"+strings.SplitN(localOrigin, "://", 2)[1])
+		}
+
+		if inject != "" {
+			if idx := strings.LastIndex(strings.ToLower(html), "</body>"); idx != -1 {
+				html = html[:idx] + inject + html[idx:]
+			} else {
+				html += inject
+			}
+		}
+
+		resp.Body = ioutil.NopCloser(bytes.NewReader([]byte(html)))
+		resp.ContentLength = int64(len(html))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(html)))
+
+		return nil
+	}
+
+	return proxy, nil
+}
@@ -0,0 +1,129 @@
+package modules
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bettercap/bettercap/log"
+)
+
+// httpServerRequestEvent is the object emitted on the session event bus as
+// "http.server.request" for every request the httpd module serves, so that
+// other modules (caplets, events.stream, ...) can react to hits in real time.
+type httpServerRequestEvent struct {
+	Time      time.Time           `json:"time"`
+	Method    string              `json:"method"`
+	URL       string              `json:"url"`
+	IP        string              `json:"ip"`
+	UserAgent string              `json:"user_agent"`
+	Headers   map[string][]string `json:"headers"`
+	Body      string              `json:"body"`
+}
+
+// maxCapturedBodySize is the maximum number of request body bytes included
+// in an http.server.request event and exposed to templates as .Body.
+const maxCapturedBodySize = 1024 * 32
+
+// readCappedBody reads the whole of r.Body and restores it unchanged so
+// that downstream handlers (the file server, uploads, the reverse proxy,
+// ...) still see the complete request, returning only the first
+// maxCapturedBodySize bytes for display in events and templates.
+func readCappedBody(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return ""
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if len(body) > maxCapturedBodySize {
+		return string(body[:maxCapturedBodySize])
+	}
+
+	return string(body)
+}
+
+// eventMiddleware wraps next, emitting an "http.server.request" event on the
+// session bus for every served request.
+func (httpd *HttpServer) eventMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := readCappedBody(r)
+
+		httpd.Session.Events.Add("http.server.request", httpServerRequestEvent{
+			Time:      time.Now(),
+			Method:    r.Method,
+			URL:       r.URL.String(),
+			IP:        strings.Split(r.RemoteAddr, ":")[0],
+			UserAgent: r.UserAgent(),
+			Headers:   r.Header,
+			Body:      body,
+		})
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// templateRequestData is exposed as the dot context when rendering a .tpl
+// file, giving the template access to the incoming request.
+type templateRequestData struct {
+	Method    string
+	URL       string
+	Path      string
+	IP        string
+	UserAgent string
+	Headers   map[string][]string
+	Query     map[string][]string
+	Form      map[string][]string
+	Body      string
+}
+
+// newTemplateFileServer returns a http.Handler that serves every file under
+// root normally, except that files whose name ends with ext are rendered
+// through html/template with the incoming request exposed as data.
+func newTemplateFileServer(root string, ext string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ext == "" || !strings.HasSuffix(r.URL.Path, ext) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body := readCappedBody(r)
+
+		if err := r.ParseMultipartForm(maxCapturedBodySize); err != nil {
+			r.ParseForm()
+		}
+
+		data := templateRequestData{
+			Method:    r.Method,
+			URL:       r.URL.String(),
+			Path:      r.URL.Path,
+			IP:        strings.Split(r.RemoteAddr, ":")[0],
+			UserAgent: r.UserAgent(),
+			Headers:   r.Header,
+			Query:     map[string][]string(r.URL.Query()),
+			Form:      map[string][]string(r.Form),
+			Body:      body,
+		}
+
+		path := filepath.Join(root, filepath.Clean("/"+r.URL.Path))
+		tpl, err := template.ParseFiles(path)
+		if err != nil {
+			log.Error("error parsing template %s: %v", path, err)
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tpl.Execute(w, data); err != nil {
+			log.Error("error rendering template %s: %v", path, err)
+		}
+	})
+}